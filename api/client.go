@@ -8,6 +8,8 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/aisk/wizard"
 	"github.com/juju/persistent-cookiejar"
@@ -50,20 +52,78 @@ type Client struct {
 	CookieJar *cookiejar.Jar
 	Region    regions.Region
 	AppID     string
+
+	// Token, when set, makes the client authenticate with an
+	// "Authorization: Bearer <token>" header instead of the cookie jar, and
+	// skips the interactive 2FA flow entirely. It is populated either by
+	// NewClientByToken or, transparently, from LEANCLOUD_API_TOKEN.
+	Token string
+
+	// TwoFactorProviders, when set, overrides the default chain of
+	// TwoFactorProvider implementations checkAndDo2FA tries in order. Leave
+	// nil to use the env/file-derived default chain.
+	TwoFactorProviders []TwoFactorProvider
+
+	// RetryPolicy, when set, overrides the default retry/backoff behavior of
+	// doRequest. Leave nil to use DefaultRetryPolicy().
+	RetryPolicy *RetryPolicy
+
+	rateLimiters   map[string]*tokenBucket
+	rateLimitersMu sync.Mutex
+}
+
+// ClientOption customizes a Client at construction time.
+type ClientOption func(*Client)
+
+// WithRetry overrides the client's retry policy, e.g. to disable retries
+// deterministically in tests: WithRetry(RetryPolicy{MaxAttempts: 1}).
+func WithRetry(policy RetryPolicy) ClientOption {
+	return func(client *Client) {
+		client.RetryPolicy = &policy
+	}
 }
 
-func NewClientByRegion(region regions.Region) *Client {
-	return &Client{
+func NewClientByRegion(region regions.Region, opts ...ClientOption) *Client {
+	client := &Client{
 		CookieJar: newCookieJar(),
 		Region:    region,
+		Token:     os.Getenv(apiTokenEnvVar),
 	}
+	for _, opt := range opts {
+		opt(client)
+	}
+	return client
 }
 
-func NewClientByApp(appID string) *Client {
-	return &Client{
+func NewClientByApp(appID string, opts ...ClientOption) *Client {
+	client := &Client{
 		CookieJar: newCookieJar(),
 		AppID:     appID,
+		Token:     os.Getenv(apiTokenEnvVar),
+	}
+	for _, opt := range opts {
+		opt(client)
 	}
+	return client
+}
+
+// NewClientByToken creates a Client that authenticates with a long-lived API
+// token instead of a cookie session. It is meant for non-interactive
+// environments (CI/CD, headless build servers, Docker images) where the
+// interactive login + 2FA flow isn't available.
+//
+// token may also be supplied via the LEANCLOUD_API_TOKEN environment
+// variable, in which case it is picked up automatically by
+// NewClientByRegion/NewClientByApp.
+func NewClientByToken(region regions.Region, token string, opts ...ClientOption) *Client {
+	client := &Client{
+		Region: region,
+		Token:  token,
+	}
+	for _, opt := range opts {
+		opt(client)
+	}
+	return client
 }
 
 func (client *Client) GetBaseURL() string {
@@ -92,22 +152,18 @@ func (client *Client) GetBaseURL() string {
 }
 
 func (client *Client) options() (*grequests.RequestOptions, error) {
-	u, err := url.Parse(client.GetBaseURL())
-	if err != nil {
-		panic(err)
-	}
-	cookies := client.CookieJar.Cookies(u)
-	xsrf := ""
-	for _, cookie := range cookies {
-		if cookie.Name == "XSRF-TOKEN" {
-			xsrf = cookie.Value
-			break
-		}
+	if client.usesTokenAuth() {
+		return &grequests.RequestOptions{
+			Headers: map[string]string{
+				"Authorization": "Bearer " + client.Token,
+			},
+			UserAgent: "LeanCloud-CLI/" + version.Version,
+		}, nil
 	}
 
 	return &grequests.RequestOptions{
 		Headers: map[string]string{
-			"X-XSRF-TOKEN": xsrf,
+			"X-XSRF-TOKEN": xsrfTokenFromJar(client.CookieJar, client.GetBaseURL()),
 		},
 		CookieJar:    client.CookieJar,
 		UseCookieJar: true,
@@ -115,7 +171,33 @@ func (client *Client) options() (*grequests.RequestOptions, error) {
 	}, nil
 }
 
+// xsrfTokenFromJar reads the double-submit XSRF-TOKEN cookie for baseURL
+// out of jar, so every authenticated call (including the session-refresh
+// call, which doesn't go through options()) sends the same header.
+func xsrfTokenFromJar(jar *cookiejar.Jar, baseURL string) string {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		panic(err)
+	}
+	for _, cookie := range jar.Cookies(u) {
+		if cookie.Name == "XSRF-TOKEN" {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+// usesTokenAuth reports whether the client should authenticate with an API
+// token instead of a cookie session.
+func (client *Client) usesTokenAuth() bool {
+	return client.Token != ""
+}
+
 func doRequest(client *Client, method string, path string, params map[string]interface{}, options *grequests.RequestOptions) (*grequests.Response, error) {
+	if err := client.ensureSessionFresh(); err != nil {
+		return nil, err
+	}
+
 	var err error
 	if options == nil {
 		if options, err = client.options(); err != nil {
@@ -125,6 +207,7 @@ func doRequest(client *Client, method string, path string, params map[string]int
 	if params != nil {
 		options.JSON = params
 	}
+
 	var fn func(string, *grequests.RequestOptions) (*grequests.Response, error)
 	switch method {
 	case "GET":
@@ -140,14 +223,47 @@ func doRequest(client *Client, method string, path string, params map[string]int
 	default:
 		panic("invalid method: " + method)
 	}
-	resp, err := fn(client.GetBaseURL()+path, options)
+
+	policy := client.retryPolicy()
+	bucket := client.rateLimiterFor(method, path)
+
+	var resp *grequests.Response
+	for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+		if attempt == 1 {
+			bucket.Take()
+		}
+
+		resp, err = fn(client.GetBaseURL()+path, options)
+
+		retryableErr := err != nil && isRetryableError(method, err)
+		retryableResp := err == nil && isRetryableStatus(method, resp.StatusCode)
+		if (!retryableErr && !retryableResp) || attempt == policy.MaxAttempts {
+			break
+		}
+
+		delay := policy.backoff(attempt)
+		if retryableResp {
+			if retryAfter, ok := parseRetryAfter(resp); ok {
+				delay = retryAfter
+			}
+		}
+		// The sleep below is the only wait for this attempt: the rate
+		// limiter bucket still accrues tokens for the elapsed time, but it
+		// must not also enforce its own wait here, or every retry would
+		// sleep twice.
+		time.Sleep(delay)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err = client.checkAndDo2FA(resp)
-	if err != nil {
-		return nil, err
+	// token-authenticated clients never go through the cookie-based 2FA
+	// challenge: the token is already a fully-authenticated credential.
+	if !client.usesTokenAuth() {
+		resp, err = client.checkAndDo2FA(resp)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	if !resp.Ok {
@@ -157,8 +273,13 @@ func doRequest(client *Client, method string, path string, params map[string]int
 		return nil, fmt.Errorf("HTTP Error: %d, %s %s", resp.StatusCode, method, path)
 	}
 
-	if err = client.CookieJar.Save(); err != nil {
-		return nil, err
+	if !client.usesTokenAuth() {
+		if err = client.CookieJar.Save(); err != nil {
+			return nil, err
+		}
+		if err = client.recordSessionExpiry(resp); err != nil {
+			return nil, err
+		}
 	}
 
 	return resp, nil
@@ -173,16 +294,68 @@ func (client *Client) checkAndDo2FA(resp *grequests.Response) (*grequests.Respon
 	var result struct {
 		Token string `json:"token"`
 	}
-	err := resp.JSON(&result)
-	if err != nil {
+	if err := resp.JSON(&result); err != nil {
 		return nil, err
 	}
-	token := result.Token
-	code, err := Get2FACode()
+	challenge := TwoFactorChallenge{Token: result.Token}
+
+	var lastErr error
+	for _, provider := range client.twoFactorProviders() {
+		resp, err := client.submit2FACode(challenge, provider)
+		if err == nil {
+			return resp, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// submit2FACode asks provider for a code and posts it to /1.1/do2fa. If
+// provider is a TOTPProvider and the code is rejected, it retries once with
+// the previous 30-second window before giving up on this provider, to
+// tolerate clock drift between the CLI and the dashboard.
+func (client *Client) submit2FACode(challenge TwoFactorChallenge, provider TwoFactorProvider) (*grequests.Response, error) {
+	code, err := provider.Code(challenge)
 	if err != nil {
 		return nil, err
 	}
 
+	resp, err := client.do2FA(challenge.Token, code)
+	confirm(provider, code, err == nil)
+	if err == nil {
+		return resp, nil
+	}
+
+	if totp, ok := provider.(*TOTPProvider); ok {
+		previousCode, codeErr := totpCode(totp.Secret, time.Now(), -1)
+		if codeErr == nil {
+			retryResp, retryErr := client.do2FA(challenge.Token, previousCode)
+			confirm(provider, previousCode, retryErr == nil)
+			if retryErr == nil {
+				return retryResp, nil
+			}
+		}
+	}
+
+	return nil, err
+}
+
+// confirm notifies provider, if it implements confirmableProvider, whether
+// the code it produced was actually accepted by the dashboard. A failure to
+// persist that (e.g. marking a recovery code used) doesn't change the
+// outcome of the login itself, but is surfaced on stderr so the user knows
+// their on-disk state may be stale.
+func confirm(provider TwoFactorProvider, code string, accepted bool) {
+	confirmable, ok := provider.(confirmableProvider)
+	if !ok {
+		return
+	}
+	if err := confirmable.Confirm(code, accepted); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to persist 2FA provider state: %s\n", err)
+	}
+}
+
+func (client *Client) do2FA(token, code string) (*grequests.Response, error) {
 	jar, err := cookiejar.New(&cookiejar.Options{
 		Filename: filepath.Join(utils.ConfigDir(), "leancloud", "cookies"),
 	})
@@ -190,7 +363,7 @@ func (client *Client) checkAndDo2FA(resp *grequests.Response) (*grequests.Respon
 		return nil, err
 	}
 
-	resp, err = grequests.Post(client.GetBaseURL()+"/1.1/do2fa", &grequests.RequestOptions{
+	resp, err := grequests.Post(client.GetBaseURL()+"/1.1/do2fa", &grequests.RequestOptions{
 		JSON: map[string]interface{}{
 			"token": token,
 			"code":  code,
@@ -0,0 +1,234 @@
+package api
+
+import (
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/levigross/grequests"
+)
+
+const (
+	maxRetriesEnvVar        = "LEANCLOUD_MAX_RETRIES"
+	retryInitialDelayEnvVar = "LEANCLOUD_RETRY_INITIAL_DELAY_MS"
+	retryMaxDelayEnvVar     = "LEANCLOUD_RETRY_MAX_DELAY_MS"
+)
+
+// RetryPolicy governs how doRequest retries transient failures: network
+// flaps, 429s, and 502/503/504s. The zero value is not valid on its own; use
+// DefaultRetryPolicy() as a starting point.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of tries, including the first one. 1
+	// disables retries entirely.
+	MaxAttempts int
+	// InitialDelay is the backoff before the second attempt.
+	InitialDelay time.Duration
+	// MaxDelay caps the backoff, however large MaxAttempts grows it.
+	MaxDelay time.Duration
+	// Jitter is the fraction (0..1) of the computed delay to randomize, so
+	// concurrent `lean` invocations don't retry in lockstep.
+	Jitter float64
+}
+
+// DefaultRetryPolicy returns the retry policy doRequest uses unless
+// Client.RetryPolicy is set, with MaxAttempts, InitialDelay and MaxDelay
+// overridable via environment variables so CI/CD environments can tune it
+// without code changes. Jitter has no environment override.
+func DefaultRetryPolicy() RetryPolicy {
+	policy := RetryPolicy{
+		MaxAttempts:  4,
+		InitialDelay: 500 * time.Millisecond,
+		MaxDelay:     10 * time.Second,
+		Jitter:       0.2,
+	}
+
+	if raw := os.Getenv(maxRetriesEnvVar); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n >= 0 {
+			policy.MaxAttempts = n + 1
+		}
+	}
+	if raw := os.Getenv(retryInitialDelayEnvVar); raw != "" {
+		if ms, err := strconv.Atoi(raw); err == nil && ms >= 0 {
+			policy.InitialDelay = time.Duration(ms) * time.Millisecond
+		}
+	}
+	if raw := os.Getenv(retryMaxDelayEnvVar); raw != "" {
+		if ms, err := strconv.Atoi(raw); err == nil && ms >= 0 {
+			policy.MaxDelay = time.Duration(ms) * time.Millisecond
+		}
+	}
+
+	return policy
+}
+
+func (client *Client) retryPolicy() RetryPolicy {
+	policy := DefaultRetryPolicy()
+	if client.RetryPolicy != nil {
+		policy = *client.RetryPolicy
+	}
+
+	// A caller passing a zero-value RetryPolicy (e.g. WithRetry(RetryPolicy{}))
+	// must still get one real attempt, not a silent no-op loop.
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	return policy
+}
+
+// backoff computes the delay before the given attempt (1-based) is retried,
+// as exponential backoff capped at MaxDelay, then randomized by up to
+// Jitter/2 of that delay in either direction.
+func (policy RetryPolicy) backoff(attempt int) time.Duration {
+	delay := policy.InitialDelay * time.Duration(1<<uint(attempt-1))
+	if delay <= 0 || delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+	if policy.Jitter <= 0 {
+		return delay
+	}
+
+	jitterRange := time.Duration(float64(delay) * policy.Jitter)
+	return delay - jitterRange/2 + time.Duration(rand.Int63n(int64(jitterRange)+1))
+}
+
+// isRetryableStatus reports whether a response status code should be
+// retried for method. POST/PATCH are non-idempotent, so only 429/503 (and
+// never 502/504, which may mean the server already processed the body) are
+// retried for them.
+func isRetryableStatus(method string, statusCode int) bool {
+	switch statusCode {
+	case 429, 503:
+		return true
+	case 502, 504:
+		return method != "POST" && method != "PATCH"
+	default:
+		return false
+	}
+}
+
+// isRetryableError reports whether a transport-level error should be
+// retried. GET/PUT/DELETE may retry on any timeout/temporary net.Error.
+// POST/PATCH are non-idempotent, so they may only retry when the failure
+// happened while establishing the connection, before the request body
+// could have reached the server.
+func isRetryableError(method string, err error) bool {
+	netErr, ok := err.(net.Error)
+	if !ok {
+		return false
+	}
+
+	if method != "POST" && method != "PATCH" {
+		return netErr.Timeout() || netErr.Temporary()
+	}
+	return isConnectionEstablishmentError(err)
+}
+
+// isConnectionEstablishmentError reports whether err comes from the dial
+// phase of the request, as opposed to writing the request or reading the
+// response, where the server may already have observed the body.
+func isConnectionEstablishmentError(err error) bool {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	opErr, ok := err.(*net.OpError)
+	return ok && opErr.Op == "dial"
+}
+
+// parseRetryAfter reads the Retry-After header, in either its
+// delta-seconds or HTTP-date form.
+func parseRetryAfter(resp *grequests.Response) (time.Duration, bool) {
+	header := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if header == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(header); err == nil {
+		return time.Until(at), true
+	}
+	return 0, false
+}
+
+const (
+	rateLimitBucketCapacity   = 5
+	rateLimitBucketRefillRate = 1 // tokens per second
+)
+
+// tokenBucket self-throttles bursts of calls against a single endpoint, so
+// e.g. `lean logs -f` polling doesn't hammer the dashboard into a 429.
+type tokenBucket struct {
+	mu         sync.Mutex
+	tokens     float64
+	capacity   float64
+	refillRate float64
+	last       time.Time
+}
+
+func newTokenBucket(capacity, refillRate float64) *tokenBucket {
+	return &tokenBucket{
+		tokens:     capacity,
+		capacity:   capacity,
+		refillRate: refillRate,
+		last:       time.Now(),
+	}
+}
+
+// Take blocks, if necessary, until a token is available.
+func (bucket *tokenBucket) Take() {
+	bucket.mu.Lock()
+	now := time.Now()
+	bucket.tokens = math.Min(bucket.capacity, bucket.tokens+now.Sub(bucket.last).Seconds()*bucket.refillRate)
+	bucket.last = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		bucket.mu.Unlock()
+		return
+	}
+
+	wait := time.Duration((1 - bucket.tokens) / bucket.refillRate * float64(time.Second))
+	bucket.tokens = 0
+	bucket.last = now.Add(wait)
+	bucket.mu.Unlock()
+
+	time.Sleep(wait)
+}
+
+// rateLimiterFor returns the token bucket for the (method, path-prefix)
+// pair, creating it on first use.
+func (client *Client) rateLimiterFor(method, path string) *tokenBucket {
+	client.rateLimitersMu.Lock()
+	defer client.rateLimitersMu.Unlock()
+
+	if client.rateLimiters == nil {
+		client.rateLimiters = map[string]*tokenBucket{}
+	}
+
+	key := method + " " + pathPrefix(path)
+	bucket, ok := client.rateLimiters[key]
+	if !ok {
+		bucket = newTokenBucket(rateLimitBucketCapacity, rateLimitBucketRefillRate)
+		client.rateLimiters[key] = bucket
+	}
+	return bucket
+}
+
+// pathPrefix collapses a request path down to its first two segments, e.g.
+// "/1.1/apps/xxx/deploy" -> "/1.1/apps", so per-endpoint throttling groups
+// requests by resource rather than by the exact URL.
+func pathPrefix(path string) string {
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if len(parts) < 2 {
+		return path
+	}
+	return "/" + parts[0] + "/" + parts[1]
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/leancloud/lean-cli/utils"
+	"github.com/levigross/grequests"
+)
+
+// sessionCookieName is the dashboard cookie that carries the session's
+// lifetime; its Expires/Max-Age attribute is what we track between requests.
+const sessionCookieName = "leancloud-session"
+
+// sessionRefreshWindowEnvVar overrides how long before expiry doRequest
+// proactively refreshes the session. Value is in minutes.
+const sessionRefreshWindowEnvVar = "LEANCLOUD_SESSION_REFRESH_WINDOW"
+
+const defaultSessionRefreshWindow = 10 * time.Minute
+
+// ErrSessionExpired is returned by doRequest when the cookie session has
+// expired and couldn't be refreshed. Callers should surface this to the
+// user and prompt them to run `lean login` again, rather than showing the
+// raw 401 response body.
+var ErrSessionExpired = errors.New("leancloud session has expired, please run `lean login` again")
+
+type sessionState struct {
+	Expires time.Time `json:"expires"`
+}
+
+func sessionStatePath() string {
+	return filepath.Join(utils.ConfigDir(), "leancloud", "session.json")
+}
+
+func loadSessionState() (*sessionState, error) {
+	data, err := ioutil.ReadFile(sessionStatePath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	state := new(sessionState)
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func saveSessionState(state *sessionState) error {
+	path := sessionStatePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0775); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+func sessionRefreshWindow() time.Duration {
+	if raw := os.Getenv(sessionRefreshWindowEnvVar); raw != "" {
+		if minutes, err := strconv.Atoi(raw); err == nil {
+			return time.Duration(minutes) * time.Minute
+		}
+	}
+	return defaultSessionRefreshWindow
+}
+
+// ensureSessionFresh refreshes the cookie session when its known expiry is
+// within the refresh window, so doRequest never has to surface an opaque
+// 401 caused by an expired session. It is a no-op for token-authenticated
+// clients, and when no expiry has been recorded yet.
+func (client *Client) ensureSessionFresh() error {
+	if client.usesTokenAuth() {
+		return nil
+	}
+
+	state, err := loadSessionState()
+	if err != nil || state == nil || state.Expires.IsZero() {
+		return nil
+	}
+
+	remaining := time.Until(state.Expires)
+	if remaining > sessionRefreshWindow() {
+		return nil
+	}
+	if remaining <= 0 {
+		return ErrSessionExpired
+	}
+
+	return client.refreshSession()
+}
+
+// refreshSession re-validates the current cookie session against the
+// dashboard's refresh endpoint, extending its lifetime without requiring
+// the user to log in (and go through 2FA) again.
+func (client *Client) refreshSession() error {
+	resp, err := grequests.Post(client.GetBaseURL()+"/1.1/refreshSession", &grequests.RequestOptions{
+		Headers: map[string]string{
+			"X-XSRF-TOKEN": xsrfTokenFromJar(client.CookieJar, client.GetBaseURL()),
+		},
+		CookieJar:    client.CookieJar,
+		UseCookieJar: true,
+	})
+	if err != nil {
+		return err
+	}
+	if !resp.Ok {
+		// Only a real expiry (401) should send the user back through
+		// `lean login`; any other failure (a transient 5xx from the
+		// refresh endpoint itself, for instance) is a normal request
+		// error and must not be conflated with an expired session.
+		if resp.StatusCode == 401 {
+			return ErrSessionExpired
+		}
+		if strings.HasPrefix(strings.TrimSpace(resp.Header.Get("Content-Type")), "application/json") {
+			return NewErrorFromResponse(resp)
+		}
+		return fmt.Errorf("HTTP Error: %d, %s %s", resp.StatusCode, "POST", "/1.1/refreshSession")
+	}
+
+	if err := client.CookieJar.Save(); err != nil {
+		return err
+	}
+	return client.recordSessionExpiry(resp)
+}
+
+// recordSessionExpiry inspects resp's Set-Cookie headers for the session
+// cookie and persists its Expires/Max-Age alongside the cookie jar, so the
+// next request knows whether a refresh is due.
+func (client *Client) recordSessionExpiry(resp *grequests.Response) error {
+	if resp.RawResponse == nil {
+		return nil
+	}
+
+	for _, cookie := range resp.RawResponse.Cookies() {
+		if cookie.Name != sessionCookieName {
+			continue
+		}
+
+		expires := cookie.Expires
+		if expires.IsZero() && cookie.MaxAge > 0 {
+			expires = time.Now().Add(time.Duration(cookie.MaxAge) * time.Second)
+		}
+		if expires.IsZero() {
+			return nil
+		}
+
+		return saveSessionState(&sessionState{Expires: expires})
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/leancloud/lean-cli/api/regions"
+	"github.com/leancloud/lean-cli/utils"
+)
+
+// apiTokenEnvVar is the environment variable that NewClientByRegion and
+// NewClientByApp check for an API token, so CI/CD pipelines and other
+// non-interactive environments can authenticate without a cookie session.
+const apiTokenEnvVar = "LEANCLOUD_API_TOKEN"
+
+// StoredToken is the locally persisted record of a long-lived API token
+// created by `lean auth token create`, scoped to the region (and optionally
+// the app) it was issued for. It never holds the raw secret: Masked is all
+// that's kept once the token has been shown to the user.
+type StoredToken struct {
+	ID        string         `json:"id"`
+	Label     string         `json:"label"`
+	Masked    string         `json:"masked"`
+	Region    regions.Region `json:"region"`
+	AppID     string         `json:"app_id,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+// maskToken reduces a token to its last 4 characters, for display in
+// `lean auth token list` without re-exposing the full secret.
+func maskToken(token string) string {
+	if len(token) <= 4 {
+		return "••••"
+	}
+	return "••••" + token[len(token)-4:]
+}
+
+func tokenStorePath() string {
+	return filepath.Join(utils.ConfigDir(), "leancloud", "tokens.json")
+}
+
+// loadStoredTokens reads the locally persisted tokens. A missing file is not
+// an error: it just means no token has been created yet.
+func loadStoredTokens() ([]StoredToken, error) {
+	data, err := ioutil.ReadFile(tokenStorePath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens []StoredToken
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func saveStoredTokens(tokens []StoredToken) error {
+	path := tokenStorePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0775); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// CreateToken provisions a new long-lived API token against the dashboard.
+// The returned token string is the raw secret, shown to the user exactly
+// once; only a masked record of it (scoped to the client's region and, if
+// set, app) is persisted under utils.ConfigDir() for `lean auth token list`.
+func (client *Client) CreateToken(label string) (token string, stored StoredToken, err error) {
+	resp, err := client.post("/1.1/clients/self/tokens", map[string]interface{}{
+		"label": label,
+	}, nil)
+	if err != nil {
+		return "", StoredToken{}, err
+	}
+
+	var result struct {
+		ID    string `json:"id"`
+		Token string `json:"token"`
+	}
+	if err := resp.JSON(&result); err != nil {
+		return "", StoredToken{}, err
+	}
+
+	stored = StoredToken{
+		ID:        result.ID,
+		Label:     label,
+		Masked:    maskToken(result.Token),
+		Region:    client.Region,
+		AppID:     client.AppID,
+		CreatedAt: time.Now(),
+	}
+
+	tokens, err := loadStoredTokens()
+	if err != nil {
+		return "", StoredToken{}, err
+	}
+	tokens = append(tokens, stored)
+	if err := saveStoredTokens(tokens); err != nil {
+		return "", StoredToken{}, err
+	}
+
+	return result.Token, stored, nil
+}
+
+// ListTokens returns the (masked) tokens previously created with
+// CreateToken, scoped to the client's region and app.
+func (client *Client) ListTokens() ([]StoredToken, error) {
+	tokens, err := loadStoredTokens()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []StoredToken
+	for _, token := range tokens {
+		if token.Region == client.Region && token.AppID == client.AppID {
+			result = append(result, token)
+		}
+	}
+	return result, nil
+}
+
+// RevokeToken revokes the token identified by id against the dashboard and
+// removes its local record.
+func (client *Client) RevokeToken(id string) error {
+	_, err := client.post("/1.1/clients/self/tokens/revoke", map[string]interface{}{
+		"id": id,
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	tokens, err := loadStoredTokens()
+	if err != nil {
+		return err
+	}
+
+	kept := tokens[:0]
+	found := false
+	for _, t := range tokens {
+		if t.ID == id {
+			found = true
+			continue
+		}
+		kept = append(kept, t)
+	}
+	if !found {
+		return fmt.Errorf("token not found in local store")
+	}
+
+	return saveStoredTokens(kept)
+}
@@ -0,0 +1,304 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/leancloud/lean-cli/utils"
+)
+
+// TwoFactorChallenge carries the information the dashboard sent back about
+// a pending 2FA challenge, for TwoFactorProvider implementations that need
+// it. None of the built-in providers reads it; the external-command
+// provider runs its binary without passing Token along.
+type TwoFactorChallenge struct {
+	Token string
+}
+
+// TwoFactorProvider produces a two-factor-authentication code for a
+// challenge. Implementations may prompt interactively, derive the code
+// locally (TOTP), consume single-use recovery codes, or shell out to an
+// external program.
+type TwoFactorProvider interface {
+	Code(challenge TwoFactorChallenge) (string, error)
+}
+
+// confirmableProvider is implemented by providers that must defer a
+// stateful/destructive side effect of the code they handed out (like
+// burning a single-use recovery code) until the dashboard has actually
+// accepted it. Confirm returns an error if it fails to persist that side
+// effect, so the caller can at least warn the user instead of silently
+// leaving the on-disk state wrong.
+type confirmableProvider interface {
+	Confirm(code string, accepted bool) error
+}
+
+// interactiveTwoFactorProvider is the original behavior: prompt on stdin via
+// Get2FACode. It remains the default so existing interactive logins keep
+// working unchanged.
+type interactiveTwoFactorProvider struct{}
+
+func (interactiveTwoFactorProvider) Code(challenge TwoFactorChallenge) (string, error) {
+	code, err := Get2FACode()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", code), nil
+}
+
+const (
+	totpSecretEnvVar      = "LEANCLOUD_2FA_SECRET"
+	recoveryCodesEnvVar   = "LEANCLOUD_2FA_RECOVERY_CODES"
+	externalCommandEnvVar = "LEANCLOUD_2FA_COMMAND"
+)
+
+func defaultTOTPSecretPath() string {
+	return filepath.Join(utils.ConfigDir(), "leancloud", "2fa_secret")
+}
+
+func defaultRecoveryCodesPath() string {
+	return filepath.Join(utils.ConfigDir(), "leancloud", "2fa_recovery_codes.json")
+}
+
+// TOTPProvider generates RFC 6238 time-based one-time codes locally from a
+// base32-encoded secret, so CI/CD environments can complete 2FA without a
+// human present.
+type TOTPProvider struct {
+	Secret string
+}
+
+// newTOTPProviderFromEnv builds a TOTPProvider from LEANCLOUD_2FA_SECRET or,
+// failing that, the secret enrolled under ~/.leancloud/2fa_secret. It
+// returns ok=false when neither is configured.
+func newTOTPProviderFromEnv() (provider *TOTPProvider, ok bool) {
+	secret := os.Getenv(totpSecretEnvVar)
+	if secret == "" {
+		data, err := ioutil.ReadFile(defaultTOTPSecretPath())
+		if err != nil {
+			return nil, false
+		}
+		secret = strings.TrimSpace(string(data))
+	}
+	if secret == "" {
+		return nil, false
+	}
+	return &TOTPProvider{Secret: secret}, true
+}
+
+func (p *TOTPProvider) Code(challenge TwoFactorChallenge) (string, error) {
+	return totpCode(p.Secret, time.Now(), 0)
+}
+
+// totpCode computes the 6-digit TOTP code for secret at t, offset by
+// windowOffset 30-second steps (used to try the previous window on retry).
+func totpCode(secret string, t time.Time, windowOffset int64) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(strings.TrimSpace(secret)))
+	if err != nil {
+		return "", fmt.Errorf("invalid TOTP secret: %s", err)
+	}
+
+	counter := t.Unix()/30 + windowOffset
+
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(buf)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	truncated := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%06d", truncated%1000000), nil
+}
+
+// RecoveryCodeProvider consumes single-use recovery codes from a file,
+// marking each code as used so it can never be replayed.
+type RecoveryCodeProvider struct {
+	FilePath string
+}
+
+type recoveryCode struct {
+	Code string `json:"code"`
+	Used bool   `json:"used"`
+}
+
+func readRecoveryCodes(path string) ([]recoveryCode, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var codes []recoveryCode
+	if err := json.Unmarshal(data, &codes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func writeRecoveryCodes(path string, codes []recoveryCode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0775); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(codes, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+func (p *RecoveryCodeProvider) Code(challenge TwoFactorChallenge) (string, error) {
+	codes, err := readRecoveryCodes(p.FilePath)
+	if err != nil {
+		return "", err
+	}
+
+	for _, code := range codes {
+		if !code.Used {
+			return code.Code, nil
+		}
+	}
+
+	return "", errors.New("no unused 2FA recovery codes left")
+}
+
+// Confirm marks code as used, but only once the dashboard has actually
+// accepted it: a failed attempt (wrong code, network blip) must not burn a
+// code out of the user's limited recovery pool. submit2FACode calls this
+// (through confirm) once it learns whether do2FA succeeded.
+func (p *RecoveryCodeProvider) Confirm(code string, accepted bool) error {
+	if !accepted {
+		return nil
+	}
+
+	codes, err := readRecoveryCodes(p.FilePath)
+	if err != nil {
+		return err
+	}
+
+	for i, c := range codes {
+		if c.Code == code {
+			codes[i].Used = true
+		}
+	}
+	return writeRecoveryCodes(p.FilePath, codes)
+}
+
+// ExternalCommandProvider shells out to a user-configured binary and reads
+// a 2FA code from its stdout. Useful for hardware tokens or password
+// managers that expose a CLI.
+type ExternalCommandProvider struct {
+	Command string
+}
+
+func (p *ExternalCommandProvider) Code(challenge TwoFactorChallenge) (string, error) {
+	out, err := exec.Command(p.Command).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// defaultTwoFactorProviders builds the provider chain checkAndDo2FA falls
+// through when Client.TwoFactorProviders isn't set explicitly: any
+// configured non-interactive providers first, the interactive prompt last
+// as the ultimate fallback.
+func defaultTwoFactorProviders() []TwoFactorProvider {
+	var providers []TwoFactorProvider
+
+	if totp, ok := newTOTPProviderFromEnv(); ok {
+		providers = append(providers, totp)
+	}
+
+	recoveryCodesPath := os.Getenv(recoveryCodesEnvVar)
+	if recoveryCodesPath == "" {
+		recoveryCodesPath = defaultRecoveryCodesPath()
+	}
+	if _, err := os.Stat(recoveryCodesPath); err == nil {
+		providers = append(providers, &RecoveryCodeProvider{FilePath: recoveryCodesPath})
+	}
+
+	if command := os.Getenv(externalCommandEnvVar); command != "" {
+		providers = append(providers, &ExternalCommandProvider{Command: command})
+	}
+
+	providers = append(providers, interactiveTwoFactorProvider{})
+	return providers
+}
+
+// twoFactorProviders returns the provider chain to try, preferring an
+// explicit Client.TwoFactorProviders over the env/file-derived default.
+func (client *Client) twoFactorProviders() []TwoFactorProvider {
+	if len(client.TwoFactorProviders) > 0 {
+		return client.TwoFactorProviders
+	}
+	return defaultTwoFactorProviders()
+}
+
+// Enroll2FA generates a new TOTP secret and a batch of recovery codes,
+// registers the secret with the dashboard, and persists both locally so
+// the TOTP/recovery-code providers can pick them up automatically. It
+// backs the `lean 2fa enroll` command.
+func (client *Client) Enroll2FA() (secret string, recoveryCodes []string, err error) {
+	secret, err = generateTOTPSecret()
+	if err != nil {
+		return "", nil, err
+	}
+
+	recoveryCodes, err = generateRecoveryCodes(10)
+	if err != nil {
+		return "", nil, err
+	}
+
+	_, err = client.post("/1.1/clients/self/2fa", map[string]interface{}{
+		"secret": secret,
+	}, nil)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if err := ioutil.WriteFile(defaultTOTPSecretPath(), []byte(secret), 0600); err != nil {
+		return "", nil, err
+	}
+
+	codes := make([]recoveryCode, len(recoveryCodes))
+	for i, code := range recoveryCodes {
+		codes[i] = recoveryCode{Code: code}
+	}
+	if err := writeRecoveryCodes(defaultRecoveryCodesPath(), codes); err != nil {
+		return "", nil, err
+	}
+
+	return secret, recoveryCodes, nil
+}
+
+func generateTOTPSecret() (string, error) {
+	raw := make([]byte, 20)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw), nil
+}
+
+func generateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+	for i := range codes {
+		raw := make([]byte, 8)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		codes[i] = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw)
+	}
+	return codes, nil
+}
@@ -0,0 +1,123 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/leancloud/lean-cli/api"
+	"github.com/urfave/cli"
+)
+
+func init() {
+	CommandList = append(CommandList, cli.Command{
+		Name:  "auth",
+		Usage: "manage authentication credentials",
+		Subcommands: []cli.Command{
+			{
+				Name:  "token",
+				Usage: "manage long-lived API tokens, for non-interactive use (CI/CD, Docker)",
+				Subcommands: []cli.Command{
+					{
+						Name:  "create",
+						Usage: "create a new API token and print it",
+						Flags: []cli.Flag{
+							cli.StringFlag{Name: regionFlagName, Usage: "cn, us or tab", Value: "cn"},
+							cli.StringFlag{Name: "app-id", Usage: "scope the token to a single app"},
+							cli.StringFlag{Name: "label", Usage: "a human-readable label for the token"},
+						},
+						Action: authTokenCreate,
+					},
+					{
+						Name:  "list",
+						Usage: "list the locally known API tokens for a region/app",
+						Flags: []cli.Flag{
+							cli.StringFlag{Name: regionFlagName, Usage: "cn, us or tab", Value: "cn"},
+							cli.StringFlag{Name: "app-id", Usage: "scope the listing to a single app"},
+						},
+						Action: authTokenList,
+					},
+					{
+						Name:      "revoke",
+						Usage:     "revoke an API token by id (see `lean auth token list`)",
+						ArgsUsage: "<id>",
+						Flags: []cli.Flag{
+							cli.StringFlag{Name: regionFlagName, Usage: "cn, us or tab", Value: "cn"},
+							cli.StringFlag{Name: "app-id", Usage: "scope the client to a single app"},
+						},
+						Action: authTokenRevoke,
+					},
+				},
+			},
+		},
+	})
+}
+
+func authClient(c *cli.Context) (*api.Client, error) {
+	if appID := c.String("app-id"); appID != "" {
+		return api.NewClientByApp(appID), nil
+	}
+	region, err := parseRegion(c.String(regionFlagName))
+	if err != nil {
+		return nil, err
+	}
+	return api.NewClientByRegion(region), nil
+}
+
+func authTokenCreate(c *cli.Context) error {
+	client, err := authClient(c)
+	if err != nil {
+		return err
+	}
+
+	token, stored, err := client.CreateToken(c.String("label"))
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Token created. Store it somewhere safe, it won't be shown again:")
+	fmt.Println(token)
+	fmt.Println()
+	fmt.Printf("id: %s (use this with `lean auth token revoke` later)\n", stored.ID)
+	fmt.Println("Use the token by setting LEANCLOUD_API_TOKEN in your environment.")
+	return nil
+}
+
+func authTokenList(c *cli.Context) error {
+	client, err := authClient(c)
+	if err != nil {
+		return err
+	}
+
+	tokens, err := client.ListTokens()
+	if err != nil {
+		return err
+	}
+
+	if len(tokens) == 0 {
+		fmt.Println("no tokens found")
+		return nil
+	}
+
+	for _, token := range tokens {
+		fmt.Printf("%s\t%s\t%s\t%s\n", token.ID, token.Label, token.Masked, token.CreatedAt.Format("2006-01-02 15:04:05"))
+	}
+	return nil
+}
+
+func authTokenRevoke(c *cli.Context) error {
+	id := c.Args().First()
+	if id == "" {
+		return fmt.Errorf("usage: lean auth token revoke <id>")
+	}
+
+	client, err := authClient(c)
+	if err != nil {
+		return err
+	}
+
+	if err := client.RevokeToken(id); err != nil {
+		return err
+	}
+
+	fmt.Println("token revoked")
+	return nil
+}
@@ -0,0 +1,11 @@
+// Package commands holds the `lean` CLI's subcommands. Each file registers
+// its cli.Command(s) into CommandList via init(), and main wires
+// CommandList into the urfave/cli App.
+package commands
+
+import (
+	"github.com/urfave/cli"
+)
+
+// CommandList is every top-level command exposed by `lean`.
+var CommandList []cli.Command
@@ -0,0 +1,24 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/leancloud/lean-cli/api/regions"
+)
+
+// regionFlagName is the --region flag shared by commands that construct an
+// api.Client directly (rather than deriving the region from an app ID).
+const regionFlagName = "region"
+
+func parseRegion(name string) (regions.Region, error) {
+	switch name {
+	case "", "cn":
+		return regions.CN, nil
+	case "us":
+		return regions.US, nil
+	case "tab":
+		return regions.TAB, nil
+	default:
+		return regions.CN, fmt.Errorf("unknown region: %s", name)
+	}
+}
@@ -0,0 +1,47 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/leancloud/lean-cli/api"
+	"github.com/urfave/cli"
+)
+
+func init() {
+	CommandList = append(CommandList, cli.Command{
+		Name:  "2fa",
+		Usage: "manage two-factor authentication",
+		Subcommands: []cli.Command{
+			{
+				Name:  "enroll",
+				Usage: "enroll in TOTP-based 2FA and generate recovery codes",
+				Flags: []cli.Flag{
+					cli.StringFlag{Name: regionFlagName, Usage: "cn, us or tab", Value: "cn"},
+					cli.StringFlag{Name: "app-id", Usage: "scope the client to a single app"},
+				},
+				Action: twoFactorEnroll,
+			},
+		},
+	})
+}
+
+func twoFactorEnroll(c *cli.Context) error {
+	client, err := authClient(c)
+	if err != nil {
+		return err
+	}
+
+	secret, recoveryCodes, err := client.Enroll2FA()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Scan this secret into your authenticator app (or set LEANCLOUD_2FA_SECRET):")
+	fmt.Println(secret)
+	fmt.Println()
+	fmt.Println("Recovery codes, each usable once if you lose access to the authenticator:")
+	for _, code := range recoveryCodes {
+		fmt.Println(code)
+	}
+	return nil
+}